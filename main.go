@@ -1,19 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/netip"
-	"os"
-	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
-
-	"github.com/bwesterb/go-zonefile"
 )
 
 var origin = flag.String("origin", "", "Origin for the zone to update")
@@ -22,60 +19,231 @@ var rZone = flag.String("rzone", "", "Reverse zone to use for the server")
 var bind = flag.String("bind", ":8080", "Bind address for the server")
 var secret = flag.String("secret", "", "Secret to allow updates")
 
+var backend = flag.String("backend", "file", `Backend to write zone updates with: "file" (edit zone files, reload nsd via systemctl) or "nsupdate" (send RFC 2136 dynamic updates)`)
+var primary = flag.String("primary", "", "Primary server address (host[:port]) to send dynamic updates to (nsupdate backend)")
+var tsigName = flag.String("tsig-name", "", "TSIG key name (nsupdate backend)")
+var tsigSecret = flag.String("tsig-secret", "", "TSIG key secret, base64 (nsupdate backend)")
+var tsigKeyFile = flag.String("tsig-keyfile", "", `Path to a file containing "name secret" for the TSIG key (nsupdate backend)`)
+
 var updateMutex = &sync.Mutex{}
+var writer ZoneWriter
 
 func main() {
 	flag.Parse()
 
-	s := http.Server{
-		Addr: *bind,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "text/plain")
+	w, err := newZoneWriter()
+	if err != nil {
+		log.Fatalf("Could not set up %s backend: %v", *backend, err)
+	}
+	writer = w
 
-			if r.Method != http.MethodGet {
-				w.WriteHeader(http.StatusMethodNotAllowed)
-				return
-			}
-			query := r.URL.Query()
-			if query.Get("secret") != *secret {
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte("Forbidden"))
-				return
-			}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", handleBatch)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
 
-			name := query.Get("name")
-			ip := query.Get("ip")
-			if name == "" || ip == "" {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("Missing name or ip"))
-				return
-			}
-			if err := Set(name, ip); err != nil {
-				log.Printf("Error setting record: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, "Error: %v", err)
-				return
-			}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("secret") != *secret {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+
+		name := query.Get("name")
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Missing name"))
+			return
+		}
 
+		if rtype := query.Get("type"); rtype != "" {
+			value := query.Get("value")
+			var serial uint32
+			var err error
+			if query.Get("op") == "delete" {
+				serial, err = Delete(name, rtype, value)
+				if err != nil {
+					log.Printf("Error deleting record: %v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprintf(w, "Error: %v", err)
+					return
+				}
+			} else {
+				if value == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("Missing value"))
+					return
+				}
+				var ttl uint32
+				if t := query.Get("ttl"); t != "" {
+					parsed, perr := strconv.ParseUint(t, 10, 32)
+					if perr != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						w.Write([]byte("Invalid ttl"))
+						return
+					}
+					ttl = uint32(parsed)
+				}
+				serial, err = Upsert(name, rtype, value, ttl)
+				if err != nil {
+					log.Printf("Error setting record: %v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprintf(w, "Error: %v", err)
+					return
+				}
+			}
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK"))
-		}),
-	}
+			fmt.Fprintf(w, "OK serial=%d", serial)
+			return
+		}
+
+		ip := query.Get("ip")
+		if ip == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Missing ip"))
+			return
+		}
+		serial, err := Set(name, ip)
+		if err != nil {
+			log.Printf("Error setting record: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Error: %v", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK serial=%d", serial)
+	})
+
+	s := http.Server{Addr: *bind, Handler: mux}
 	s.ListenAndServe()
 }
 
-func Set(name string, ip string) error {
+// batchResponse is the JSON body returned by POST /update.
+type batchResponse struct {
+	Serials map[string]uint32 `json:"serials,omitempty"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// handleBatch serves POST /update: a JSON array of BatchEntry describing
+// several record changes to apply atomically.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("secret") != *secret {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(batchResponse{Errors: []string{"Forbidden"}})
+		return
+	}
+
+	var entries []BatchEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(batchResponse{Errors: []string{fmt.Sprintf("invalid request body: %v", err)}})
+		return
+	}
+
+	serials, err := Batch(entries)
+	if err != nil {
+		log.Printf("Error applying batch update: %v", err)
+		resp := batchResponse{Serials: serials}
+		status := http.StatusInternalServerError
+		var berr *BatchError
+		var serr *BatchSendError
+		switch {
+		case errors.As(err, &berr):
+			status = http.StatusBadRequest
+			resp.Errors = berr.Failures
+		case errors.As(err, &serr):
+			status = http.StatusBadGateway
+			resp.Errors = serr.Failures
+		default:
+			resp.Errors = []string{err.Error()}
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(batchResponse{Serials: serials})
+}
+
+// Batch applies every entry atomically via the configured ZoneWriter and
+// reloads the nameserver once, after every affected zone is durable.
+func Batch(entries []BatchEntry) (map[string]uint32, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	serials, err := writer.Batch(entries)
+	if err != nil {
+		return serials, err
+	}
+	return serials, writer.Reload()
+}
+
+// zoneFor returns the zone that holds records of rtype: the reverse zone
+// for PTRs, the forward zone for everything else. Depending on -backend
+// this is a file path (file backend) or a zone apex name (nsupdate backend).
+func zoneFor(rtype string) string {
+	if strings.ToUpper(rtype) == "PTR" {
+		return *rZone
+	}
+	return *fZone
+}
+
+// Upsert sets the value of a single record of the given name and type,
+// using the configured ZoneWriter, and reloads the nameserver. It returns
+// the zone's new SOA serial so the caller can confirm what was published.
+func Upsert(name, rtype, value string, ttl uint32) (uint32, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	serial, err := writer.Upsert(zoneFor(rtype), name, rtype, value, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return serial, writer.Reload()
+}
+
+// Delete removes a record of the given name and type (optionally matching a
+// specific value), using the configured ZoneWriter, and reloads the
+// nameserver. It returns the zone's new SOA serial.
+func Delete(name, rtype, value string) (uint32, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	serial, err := writer.Delete(zoneFor(rtype), name, rtype, value)
+	if err != nil {
+		return 0, err
+	}
+	return serial, writer.Reload()
+}
+
+// Set updates the forward (A/AAAA) and reverse (PTR) records for name/ip and
+// reloads the nameserver. It returns the forward zone's new SOA serial.
+func Set(name string, ip string) (uint32, error) {
 	updateMutex.Lock()
 	defer updateMutex.Unlock()
 
 	nip, err := netip.ParseAddr(ip)
 	if err != nil {
-		return fmt.Errorf("invalid IP address: %v", err)
+		return 0, fmt.Errorf("invalid IP address: %v", err)
 	}
 	rname := fmt.Sprintf("%s.%s.", name, *origin)
+
+	var serial uint32
 	if nip.Is6() {
-		if err = UpdateRecordInFile(*fZone, name, "AAAA", ip); err != nil {
-			return fmt.Errorf("could not update forward AAAA record: %v", err)
+		if serial, err = writer.Upsert(*fZone, name, "AAAA", ip, 0); err != nil {
+			return 0, fmt.Errorf("could not update forward AAAA record: %v", err)
 		}
 		ipb := nip.As16()
 		ipHex := fmt.Sprintf("%x", ipb)
@@ -84,100 +252,19 @@ func Set(name string, ip string) error {
 			rHex += fmt.Sprintf("%s.", ipHex[i-1:i])
 		}
 		xformIP := fmt.Sprintf("%sip6.arpa.", rHex)
-		if err = UpdateRecordInFile(*rZone, xformIP, "PTR", rname); err != nil {
-			return fmt.Errorf("could not update reverse A PTR record: %v", err)
+		if _, err = writer.Upsert(*rZone, xformIP, "PTR", rname, 0); err != nil {
+			return 0, fmt.Errorf("could not update reverse A PTR record: %v", err)
 		}
 	} else {
-		if err = UpdateRecordInFile(*fZone, name, "A", ip); err != nil {
-			return fmt.Errorf("could not update forward A record: %v", err)
+		if serial, err = writer.Upsert(*fZone, name, "A", ip, 0); err != nil {
+			return 0, fmt.Errorf("could not update forward A record: %v", err)
 		}
 		ipb := nip.As4()
 		xformIP := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ipb[3], ipb[2], ipb[1], ipb[0])
-		if err = UpdateRecordInFile(*rZone, xformIP, "PTR", rname); err != nil {
-			return fmt.Errorf("could not update reverse A PTR record: %v", err)
-		}
-	}
-
-	// try now to reload
-	cmd := exec.Command("/usr/bin/systemctl", "reload", "nsd")
-	err = cmd.Run()
-
-	return err
-}
-
-func UpdateRecordInFile(fName string, name, rtype, value string) error {
-	file, err := os.OpenFile(fName, os.O_RDWR, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	currentData, err := io.ReadAll(file)
-	if err != nil {
-		return err
-	}
-
-	zf, perr := zonefile.Load(currentData)
-	if perr != nil {
-		return perr
-	}
-
-	soaOK := false
-	rcrdFnd := false
-	for _, e := range zf.Entries() {
-		if bytes.Equal(e.Type(), []byte(rtype)) && bytes.Equal(e.Domain(), []byte(name)) {
-			rcrdFnd = true
-			if err := e.SetValue(0, []byte(value)); err != nil {
-				log.Print("Could not set value:", err)
-				return err
-			}
-		}
-		if !bytes.Equal(e.Type(), []byte("SOA")) {
-			continue
-		}
-		vs := e.Values()
-		if len(vs) != 7 {
-			return fmt.Errorf("wrong number of parameters to SOA line")
-		}
-		serial, err := strconv.Atoi(string(vs[2]))
-		if err != nil {
-			log.Print("Could not parse serial:", err)
-			return err
-		}
-		e.SetValue(2, []byte(strconv.Itoa(serial+1)))
-		soaOK = true
-	}
-	if !soaOK {
-		return fmt.Errorf("could not find SOA entry")
-	}
-	if !rcrdFnd {
-		// add
-		if rtype == "PTR" {
-			rr, err := zonefile.ParseEntry([]byte(fmt.Sprintf("%s IN %s %s", name, rtype, value)))
-			if err != nil {
-				return err
-			}
-			zf.AddEntry(rr)
-		} else {
-			rr, err := zonefile.ParseEntry([]byte(fmt.Sprintf("%s %s %s", name, rtype, value)))
-			if err != nil {
-				return err
-			}
-			zf.AddEntry(rr)
+		if _, err = writer.Upsert(*rZone, xformIP, "PTR", rname, 0); err != nil {
+			return 0, fmt.Errorf("could not update reverse A PTR record: %v", err)
 		}
 	}
 
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return err
-	}
-	if _, err := file.Write(zf.Save()); err != nil {
-		return err
-	}
-	if !rcrdFnd {
-		file.WriteString("\n")
-	}
-	return nil
+	return serial, writer.Reload()
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpsertLowercasePTRGoesToReverseZone(t *testing.T) {
+	dir := t.TempDir()
+	fwdPath := filepath.Join(dir, "forward.zone")
+	revPath := filepath.Join(dir, "reverse.zone")
+	soa := "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2026072900 3600 600 604800 3600\n"
+	if err := os.WriteFile(fwdPath, []byte(soa), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(revPath, []byte(soa), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prevF, prevR := *fZone, *rZone
+	*fZone, *rZone = fwdPath, revPath
+	defer func() { *fZone, *rZone = prevF, prevR }()
+
+	// Go through zoneFor + fileZoneWriter.Upsert directly rather than the
+	// package-level Upsert: that also calls writer.Reload(), which for the
+	// file backend shells out to systemctl and has nothing to do with the
+	// routing behavior this test checks.
+	var fw fileZoneWriter
+	if _, err := fw.Upsert(zoneFor("ptr"), "1.2.3.4.in-addr.arpa.", "ptr", "host.example.com.", 0); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+
+	rev, err := os.ReadFile(revPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rev), "host.example.com.") {
+		t.Errorf("lowercase ptr type didn't land in the reverse zone:\n%s", rev)
+	}
+
+	fwd, err := os.ReadFile(fwdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(fwd), "host.example.com.") {
+		t.Errorf("lowercase ptr type leaked into the forward zone:\n%s", fwd)
+	}
+}
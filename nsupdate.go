@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// nsupdateWriter applies zone changes via RFC 2136 DNS UPDATE messages sent
+// to a primary server over TCP, authenticated with TSIG. zone is a DNS zone
+// name (apex), not a file path: the primary server owns the zone file and
+// bumps its own serial on accepting the update.
+type nsupdateWriter struct {
+	primary    string
+	tsigName   string
+	tsigSecret string
+}
+
+// newNSUpdateWriter builds an nsupdateWriter. The TSIG key can be supplied
+// directly via name/secret, or read from keyFile (a single line of the form
+// "name secret"), which takes precedence when set.
+func newNSUpdateWriter(primary, name, secret, keyFile string) (*nsupdateWriter, error) {
+	if primary == "" {
+		return nil, fmt.Errorf("nsupdate backend requires -primary")
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tsig keyfile: %v", err)
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("tsig keyfile must contain \"name secret\"")
+		}
+		name, secret = fields[0], fields[1]
+	}
+	if name == "" || secret == "" {
+		return nil, fmt.Errorf("nsupdate backend requires a TSIG key name and secret")
+	}
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		primary = net.JoinHostPort(primary, "53")
+	}
+	return &nsupdateWriter{primary: primary, tsigName: dns.Fqdn(name), tsigSecret: secret}, nil
+}
+
+func (n *nsupdateWriter) send(m *dns.Msg) error {
+	m.SetTsig(n.tsigName, dns.HmacSHA256, 300, time.Now().Unix())
+
+	c := new(dns.Client)
+	c.Net = "tcp"
+	c.TsigSecret = map[string]string{n.tsigName: n.tsigSecret}
+
+	reply, _, err := c.Exchange(m, n.primary)
+	if err != nil {
+		return fmt.Errorf("could not send update to %s: %v", n.primary, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update to %s rejected: %s", n.primary, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+// stage validates entry e and appends the RFC 2136 records it implies onto
+// m, without sending anything. Upsert, Delete and Batch all go through
+// this, so there's exactly one place that knows how to turn a BatchEntry
+// into update records.
+func (n *nsupdateWriter) stage(m *dns.Msg, e BatchEntry) error {
+	qname := dns.Fqdn(e.Name)
+	rrtype, ok := dns.StringToType[strings.ToUpper(e.Type)]
+	if !ok {
+		return fmt.Errorf("unknown record type %q", e.Type)
+	}
+
+	switch e.Op {
+	case "upsert":
+		ttl := e.TTL
+		if ttl == 0 {
+			ttl = 3600
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", qname, ttl, strings.ToUpper(e.Type), e.Value))
+		if err != nil {
+			return fmt.Errorf("invalid record: %v", err)
+		}
+
+		// An RFC 2136 "delete this rrset" record must carry a bare header
+		// (no rdata) — it's not the old value, and there's no need to
+		// know what that was: it just says wipe the rrset before the new
+		// value is inserted, the same way the no-value delete below does.
+		ctor, ok := dns.TypeToRR[rrtype]
+		if !ok {
+			return fmt.Errorf("unsupported record type %q", e.Type)
+		}
+		old := ctor()
+		old.Header().Name = qname
+		old.Header().Rrtype = rrtype
+
+		m.RemoveRRset([]dns.RR{old})
+		m.Insert([]dns.RR{rr})
+	case "delete":
+		if e.Value == "" {
+			ctor, ok := dns.TypeToRR[rrtype]
+			if !ok {
+				return fmt.Errorf("unsupported record type %q", e.Type)
+			}
+			rr := ctor()
+			rr.Header().Name = qname
+			rr.Header().Rrtype = rrtype
+			m.RemoveRRset([]dns.RR{rr})
+		} else {
+			rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", qname, strings.ToUpper(e.Type), e.Value))
+			if err != nil {
+				return fmt.Errorf("invalid record value: %v", err)
+			}
+			m.Remove([]dns.RR{rr})
+		}
+	default:
+		return fmt.Errorf("unknown op %q", e.Op)
+	}
+	return nil
+}
+
+func (n *nsupdateWriter) Upsert(zone, name, rtype, value string, ttl uint32) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	if err := n.stage(m, BatchEntry{Op: "upsert", Name: name, Type: rtype, Value: value, TTL: ttl}); err != nil {
+		return 0, err
+	}
+	if err := n.send(m); err != nil {
+		return 0, err
+	}
+	return n.serial(zone), nil
+}
+
+func (n *nsupdateWriter) Delete(zone, name, rtype, value string) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	if err := n.stage(m, BatchEntry{Op: "delete", Name: name, Type: rtype, Value: value}); err != nil {
+		return 0, err
+	}
+	if err := n.send(m); err != nil {
+		return 0, err
+	}
+	return n.serial(zone), nil
+}
+
+// Batch validates and stages every entry, grouped by zone, before sending
+// anything: a malformed entry anywhere means nothing touches the wire, so
+// within a zone this really is all-or-nothing. Once staging succeeds, one
+// UPDATE message per zone is sent, so a same-zone batch (the common
+// IPv4+IPv6+PTR+CNAME case this feature was built for) is a single atomic
+// RFC 2136 transaction. Across zones it's still best-effort: each zone's
+// UPDATE is its own transaction against the primary, so a later zone's
+// send failing doesn't undo an earlier zone that already succeeded.
+func (n *nsupdateWriter) Batch(entries []BatchEntry) (map[string]uint32, error) {
+	msgs := map[string]*dns.Msg{}
+	var zones []string
+	var failures []string
+
+	for i, e := range entries {
+		zone := zoneFor(e.Type)
+		m, ok := msgs[zone]
+		if !ok {
+			m = new(dns.Msg)
+			m.SetUpdate(dns.Fqdn(zone))
+			msgs[zone] = m
+			zones = append(zones, zone)
+		}
+		if err := n.stage(m, e); err != nil {
+			failures = append(failures, fmt.Sprintf("entry %d (%s %s %s): %v", i, e.Op, e.Type, e.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return nil, &BatchError{Failures: failures}
+	}
+
+	serials := make(map[string]uint32, len(zones))
+	var sendFailures []string
+	for _, zone := range zones {
+		if err := n.send(msgs[zone]); err != nil {
+			sendFailures = append(sendFailures, fmt.Sprintf("zone %s: %v", zone, err))
+			continue
+		}
+		serials[zone] = n.serial(zone)
+	}
+	if len(sendFailures) > 0 {
+		return serials, &BatchSendError{Failures: sendFailures}
+	}
+	return serials, nil
+}
+
+// serial looks up the zone's current SOA serial from the primary, so the
+// caller can report what was actually published. The primary bumps its own
+// serial on accepting an update, so this is the only way we know the
+// result; a failed lookup is non-fatal and just reports serial 0.
+func (n *nsupdateWriter) serial(zone string) uint32 {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), dns.TypeSOA)
+
+	c := new(dns.Client)
+	c.Net = "tcp"
+	reply, _, err := c.Exchange(m, n.primary)
+	if err != nil {
+		return 0
+	}
+	for _, rr := range reply.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial
+		}
+	}
+	return 0
+}
+
+// Reload is a no-op: the primary server applies the update live as part of
+// processing it, there's nothing further to signal.
+func (n *nsupdateWriter) Reload() error {
+	return nil
+}
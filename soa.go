@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// bumpSerial computes the next SOA serial from old, following the common
+// BIND "YYYYMMDDnn" convention. It does not check whether old looks
+// date-based: any serial below today's YYYYMMDD00 (a plain counter too) is
+// treated as from an earlier day:
+//
+//   - old is from today (UTC): increment by one, the nn'th update of the day;
+//   - old is from an earlier day: jump forward to today's YYYYMMDD00;
+//   - old is from the future (clock skew, or already bumped past today):
+//     increment by one rather than going backwards.
+//
+// The increment always uses RFC 1982 unsigned wraparound arithmetic
+// (s' = (s + 1) mod 2^32) and never returns 0, which is reserved.
+func bumpSerial(old uint32) uint32 {
+	now := time.Now().UTC()
+	today := uint32(now.Year())*1000000 + uint32(now.Month())*10000 + uint32(now.Day())*100
+
+	var next uint32
+	switch {
+	case old/100 == today/100:
+		next = old + 1
+	case old < today:
+		next = today
+	default:
+		next = old + 1
+	}
+	if next == 0 {
+		next = 1
+	}
+	return next
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func todaySerial() uint32 {
+	now := time.Now().UTC()
+	return uint32(now.Year())*1000000 + uint32(now.Month())*10000 + uint32(now.Day())*100
+}
+
+func TestBumpSerialSameDay(t *testing.T) {
+	old := todaySerial() + 41
+	if got, want := bumpSerial(old), old+1; got != want {
+		t.Errorf("bumpSerial(%d) = %d, want %d", old, got, want)
+	}
+}
+
+func TestBumpSerialPastDate(t *testing.T) {
+	old := todaySerial() - 1000000 // a year-ish in the past, still YYYYMMDDnn shaped
+	if got, want := bumpSerial(old), todaySerial(); got != want {
+		t.Errorf("bumpSerial(%d) = %d, want today's serial %d", old, got, want)
+	}
+}
+
+func TestBumpSerialFutureDate(t *testing.T) {
+	old := todaySerial() + 1000000 // manually bumped into the future
+	if got, want := bumpSerial(old), old+1; got != want {
+		t.Errorf("bumpSerial(%d) = %d, want %d", old, got, want)
+	}
+}
+
+func TestBumpSerialWraparoundAvoidsZero(t *testing.T) {
+	if got := bumpSerial(^uint32(0)); got != 1 {
+		t.Errorf("bumpSerial(math.MaxUint32) = %d, want 1", got)
+	}
+}
+
+func TestBumpSerialNonDateSerial(t *testing.T) {
+	// An old-style plain counter serial, far below any real date: still
+	// treated as "from the past" and jumped forward to today.
+	old := uint32(42)
+	if got, want := bumpSerial(old), todaySerial(); got != want {
+		t.Errorf("bumpSerial(%d) = %d, want %d", old, got, want)
+	}
+}
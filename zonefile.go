@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Zone is an in-memory, line-oriented view of a BIND-style zone file. Lines
+// that aren't resource records (comments, blank lines, $ORIGIN/$TTL
+// directives, anything we can't parse) are kept verbatim so Save round-trips
+// the file without reformatting anything the operator didn't ask to change.
+type Zone struct {
+	path  string
+	lines []zoneLine
+}
+
+// zoneLine is either a parsed resource record (rr set) or an opaque line of
+// text that is written back out unmodified.
+type zoneLine struct {
+	raw string
+	rr  dns.RR
+}
+
+// loadZone reads and parses the zone file at path.
+func loadZone(path string) (*Zone, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	z := &Zone{path: path}
+	var header strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, ";"):
+			z.lines = append(z.lines, zoneLine{raw: line})
+			continue
+		case strings.HasPrefix(trimmed, "$ORIGIN"), strings.HasPrefix(trimmed, "$TTL"):
+			// Feed directives into every subsequent dns.NewRR call so
+			// relative names and TTLs keep resolving correctly.
+			header.WriteString(line)
+			header.WriteString("\n")
+			z.lines = append(z.lines, zoneLine{raw: line})
+			continue
+		}
+
+		rr, rerr := dns.NewRR(header.String() + line)
+		if rerr != nil || rr == nil {
+			// Not parseable (multi-line record, stray text, ...): keep it
+			// as-is rather than failing the whole load over one line.
+			z.lines = append(z.lines, zoneLine{raw: line})
+			continue
+		}
+		z.lines = append(z.lines, zoneLine{raw: line, rr: rr})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Save serializes the zone back to its file, preserving comments,
+// directives and any untouched record's original text exactly: raw always
+// holds a line's current text, whether that's what was loaded from disk or
+// the canonical form of a record this Zone just changed (see Upsert,
+// Delete and BumpSerial), so only lines this Zone actually mutated get
+// reformatted. It writes to a temporary file in the same directory,
+// fsyncs it, and renames it into place, so a reload (ours or nsd's own)
+// never observes a half-written zone file.
+func (z *Zone) Save() error {
+	tmpPath, err := z.stage()
+	if err != nil {
+		return err
+	}
+	return z.commit(tmpPath)
+}
+
+// stage writes the zone's current lines to a new temporary file in the
+// same directory and fsyncs it, returning its path without touching
+// z.path. Callers that must publish several zones together (see
+// fileZoneWriter.Batch) can stage every one of them before committing any,
+// so a write or fsync failure partway through never leaves some zones
+// updated and others not.
+func (z *Zone) stage() (string, error) {
+	dir := filepath.Dir(z.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(z.path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+
+	w := bufio.NewWriter(tmp)
+	for _, l := range z.lines {
+		fmt.Fprintln(w, l.raw)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// commit renames a file staged by stage into place as z.path and fsyncs
+// the directory, so a reload (ours or nsd's own) never observes a
+// half-written zone file.
+func (z *Zone) commit(tmpPath string) error {
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+	if err := os.Rename(tmpPath, z.path); err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(z.path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// soaIndex returns the index of the zone's SOA record, or -1 if it has none.
+func (z *Zone) soaIndex() int {
+	for i, l := range z.lines {
+		if l.rr != nil && l.rr.Header().Rrtype == dns.TypeSOA {
+			return i
+		}
+	}
+	return -1
+}
+
+// SOA returns the zone's SOA record, validated to have all seven fields
+// (MNAME, RNAME, SERIAL, REFRESH, RETRY, EXPIRE, MINIMUM), or an error if
+// it has none.
+func (z *Zone) SOA() (*dns.SOA, error) {
+	i := z.soaIndex()
+	if i < 0 {
+		return nil, fmt.Errorf("could not find SOA entry")
+	}
+	soa, ok := z.lines[i].rr.(*dns.SOA)
+	if !ok {
+		return nil, fmt.Errorf("SOA entry has unexpected type")
+	}
+	if soa.Ns == "" || soa.Mbox == "" {
+		return nil, fmt.Errorf("SOA entry is missing MNAME or RNAME")
+	}
+	return soa, nil
+}
+
+// BumpSerial advances the zone's SOA serial per the YYYYMMDDnn convention
+// (see bumpSerial) and returns the new value.
+func (z *Zone) BumpSerial() (uint32, error) {
+	soa, err := z.SOA()
+	if err != nil {
+		return 0, err
+	}
+	soa.Serial = bumpSerial(soa.Serial)
+	// Save now just writes raw verbatim, so the mutated SOA line's raw
+	// text has to be regenerated here or the new serial would never reach
+	// disk.
+	if i := z.soaIndex(); i >= 0 {
+		z.lines[i].raw = soa.String()
+	}
+	return soa.Serial, nil
+}
+
+// Upsert finds the record matching name and rtype and replaces its value
+// and ttl, or appends a new record if none exists yet. A ttl of 0 means
+// 3600; the existing record's ttl is not kept.
+func (z *Zone) Upsert(name, rtype, value string, ttl uint32) error {
+	qname := dns.Fqdn(name)
+	rrtype, ok := dns.StringToType[strings.ToUpper(rtype)]
+	if !ok {
+		return fmt.Errorf("unknown record type %q", rtype)
+	}
+	if ttl == 0 {
+		ttl = 3600
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", qname, ttl, strings.ToUpper(rtype), value))
+	if err != nil {
+		return fmt.Errorf("invalid record: %v", err)
+	}
+
+	for i, l := range z.lines {
+		if l.rr == nil {
+			continue
+		}
+		h := l.rr.Header()
+		if h.Rrtype == rrtype && strings.EqualFold(h.Name, qname) {
+			z.lines[i] = zoneLine{raw: rr.String(), rr: rr}
+			return nil
+		}
+	}
+
+	z.lines = append(z.lines, zoneLine{raw: rr.String(), rr: rr})
+	return nil
+}
+
+// Delete removes the record matching name and rtype. If value is non-empty,
+// only a record whose rdata matches value is removed; otherwise every
+// record of that name/type is. It returns an error if nothing matched.
+func (z *Zone) Delete(name, rtype, value string) error {
+	qname := dns.Fqdn(name)
+	rrtype, ok := dns.StringToType[strings.ToUpper(rtype)]
+	if !ok {
+		return fmt.Errorf("unknown record type %q", rtype)
+	}
+
+	var wantRdata string
+	if value != "" {
+		cand, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s %s", qname, strings.ToUpper(rtype), value))
+		if err != nil {
+			return fmt.Errorf("invalid record value: %v", err)
+		}
+		wantRdata = rdata(cand)
+	}
+
+	found := false
+	remaining := z.lines[:0]
+	for _, l := range z.lines {
+		if l.rr != nil {
+			h := l.rr.Header()
+			if h.Rrtype == rrtype && strings.EqualFold(h.Name, qname) &&
+				(value == "" || rdata(l.rr) == wantRdata) {
+				found = true
+				continue
+			}
+		}
+		remaining = append(remaining, l)
+	}
+	z.lines = remaining
+
+	if !found {
+		return fmt.Errorf("no matching record found")
+	}
+	return nil
+}
+
+// rdata returns just the rdata portion of rr.String(), ignoring name, ttl
+// and class, so two records can be compared regardless of those fields.
+func rdata(rr dns.RR) string {
+	parts := strings.SplitN(rr.String(), "\t", 5)
+	if len(parts) < 5 {
+		return ""
+	}
+	return strings.TrimSpace(parts[4])
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ZoneWriter applies record mutations and, once a change has been made
+// durable, makes sure the serving nameserver picks it up. Set and the
+// generic Upsert/Delete handlers are backend-agnostic; which ZoneWriter
+// they use is chosen by the -backend flag.
+type ZoneWriter interface {
+	// Upsert sets the value of a single record of name/rtype in zone,
+	// returning the zone's serial after the change so callers can report
+	// it back to whoever asked for the update.
+	Upsert(zone, name, rtype, value string, ttl uint32) (uint32, error)
+	// Delete removes a record of name/rtype (optionally matching value)
+	// from zone, returning the zone's serial after the change.
+	Delete(zone, name, rtype, value string) (uint32, error)
+	// Batch applies every entry as a single all-or-nothing transaction,
+	// returning the new serial of each zone touched. If any entry fails to
+	// validate, nothing is published and the error is a *BatchError
+	// listing which entries failed. Once every entry has validated,
+	// publishing itself is still subject to the usual caveats of writing
+	// to multiple independent resources (files, nameservers): an I/O or
+	// transport failure partway through can leave some zones published and
+	// others not, reported as a *BatchSendError.
+	Batch(entries []BatchEntry) (map[string]uint32, error)
+	// Reload signals the nameserver to pick up whatever was just written.
+	Reload() error
+}
+
+// BatchEntry describes a single record mutation within a Batch call.
+type BatchEntry struct {
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl,omitempty"`
+}
+
+// BatchError reports which entries of a Batch call failed validation; none
+// of the batch was published.
+type BatchError struct {
+	Failures []string
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d entries failed: %s", len(e.Failures), strings.Join(e.Failures, "; "))
+}
+
+// BatchSendError reports zones that failed to publish after every entry in
+// the batch had already validated successfully. Unlike BatchError, this is
+// not the caller's fault: it's the backend (primary unreachable, TSIG
+// rejected, disk write failed, ...) failing to durably apply a request that
+// was well-formed.
+type BatchSendError struct {
+	Failures []string
+}
+
+func (e *BatchSendError) Error() string {
+	return fmt.Sprintf("%d zones failed to publish: %s", len(e.Failures), strings.Join(e.Failures, "; "))
+}
+
+// fileZoneWriter edits zone files on disk directly and reloads nsd via
+// systemctl. zone is a file path. This is the original, default backend.
+type fileZoneWriter struct{}
+
+func (fileZoneWriter) Upsert(zone, name, rtype, value string, ttl uint32) (uint32, error) {
+	z, err := loadZone(zone)
+	if err != nil {
+		return 0, err
+	}
+	if err := z.Upsert(name, rtype, value, ttl); err != nil {
+		return 0, err
+	}
+	serial, err := z.BumpSerial()
+	if err != nil {
+		return 0, err
+	}
+	return serial, z.Save()
+}
+
+func (fileZoneWriter) Delete(zone, name, rtype, value string) (uint32, error) {
+	z, err := loadZone(zone)
+	if err != nil {
+		return 0, err
+	}
+	if err := z.Delete(name, rtype, value); err != nil {
+		return 0, err
+	}
+	serial, err := z.BumpSerial()
+	if err != nil {
+		return 0, err
+	}
+	return serial, z.Save()
+}
+
+// Batch loads each affected zone file once, applies every entry to it in
+// memory, and only once all entries have validated does it bump each
+// zone's serial exactly once and write every file out durably. If any
+// entry fails, the in-memory zones are simply discarded: nothing has
+// touched disk yet, so there's nothing to undo. Durable publishing itself
+// stages every zone's new contents to a temp file (and fsyncs it) before
+// renaming any of them into place, so the only way a batch can end up
+// partially published is a rename itself failing after every zone already
+// staged cleanly — far less likely than a later zone's write failing after
+// an earlier one is already live.
+func (fileZoneWriter) Batch(entries []BatchEntry) (map[string]uint32, error) {
+	zones := map[string]*Zone{}
+	var order []string
+	var failures []string
+
+	for i, e := range entries {
+		path := zoneFor(e.Type)
+		z, ok := zones[path]
+		if !ok {
+			loaded, err := loadZone(path)
+			if err != nil {
+				return nil, fmt.Errorf("could not load zone for entry %d: %v", i, err)
+			}
+			z = loaded
+			zones[path] = z
+			order = append(order, path)
+		}
+
+		var err error
+		switch e.Op {
+		case "upsert":
+			err = z.Upsert(e.Name, e.Type, e.Value, e.TTL)
+		case "delete":
+			err = z.Delete(e.Name, e.Type, e.Value)
+		default:
+			err = fmt.Errorf("unknown op %q", e.Op)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("entry %d (%s %s %s): %v", i, e.Op, e.Type, e.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return nil, &BatchError{Failures: failures}
+	}
+
+	serials := make(map[string]uint32, len(order))
+	for _, path := range order {
+		serial, err := zones[path].BumpSerial()
+		if err != nil {
+			return nil, fmt.Errorf("could not bump serial for %s: %v", path, err)
+		}
+		serials[path] = serial
+	}
+
+	tmpPaths := make(map[string]string, len(order))
+	for _, path := range order {
+		tmpPath, err := zones[path].stage()
+		if err != nil {
+			for _, t := range tmpPaths {
+				os.Remove(t)
+			}
+			return nil, &BatchSendError{Failures: []string{fmt.Sprintf("could not stage %s: %v", path, err)}}
+		}
+		tmpPaths[path] = tmpPath
+	}
+
+	var sendFailures []string
+	for _, path := range order {
+		if err := zones[path].commit(tmpPaths[path]); err != nil {
+			sendFailures = append(sendFailures, fmt.Sprintf("could not durably write %s: %v", path, err))
+		}
+	}
+	if len(sendFailures) > 0 {
+		return serials, &BatchSendError{Failures: sendFailures}
+	}
+	return serials, nil
+}
+
+func (fileZoneWriter) Reload() error {
+	return exec.Command("/usr/bin/systemctl", "reload", "nsd").Run()
+}
+
+// newZoneWriter builds the ZoneWriter selected by -backend.
+func newZoneWriter() (ZoneWriter, error) {
+	switch *backend {
+	case "", "file":
+		return fileZoneWriter{}, nil
+	case "nsupdate":
+		return newNSUpdateWriter(*primary, *tsigName, *tsigSecret, *tsigKeyFile)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", *backend)
+	}
+}